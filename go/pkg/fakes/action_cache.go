@@ -0,0 +1,67 @@
+package fakes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ActionCache is a fake action cache for use in integration tests.
+type ActionCache struct {
+	mu      sync.Mutex
+	results map[digest.Digest]*repb.ActionResult
+}
+
+// NewActionCache returns a new empty fake ActionCache.
+func NewActionCache() *ActionCache {
+	return &ActionCache{results: make(map[digest.Digest]*repb.ActionResult)}
+}
+
+// Clear removes all results from the fake action cache.
+func (a *ActionCache) Clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.results = make(map[digest.Digest]*repb.ActionResult)
+}
+
+// Put inserts the given ActionResult into the fake cache, keyed by the digest of the Action.
+func (a *ActionCache) Put(dg digest.Digest, ar *repb.ActionResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.results[dg] = ar
+}
+
+// Get returns the cached ActionResult for the given Action digest, if present.
+func (a *ActionCache) Get(dg digest.Digest) (*repb.ActionResult, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ar, ok := a.results[dg]
+	return ar, ok
+}
+
+// GetActionResult implements the ActionCache service.
+func (a *ActionCache) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest) (*repb.ActionResult, error) {
+	dg, err := digest.NewFromProto(req.ActionDigest)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid action digest: %v", err)
+	}
+	ar, ok := a.Get(dg)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "action result for %v not found", dg)
+	}
+	return ar, nil
+}
+
+// UpdateActionResult implements the ActionCache service.
+func (a *ActionCache) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error) {
+	dg, err := digest.NewFromProto(req.ActionDigest)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid action digest: %v", err)
+	}
+	a.Put(dg, req.ActionResult)
+	return req.ActionResult, nil
+}
@@ -0,0 +1,187 @@
+package fakes
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"github.com/golang/protobuf/proto"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/grpc/codes"
+)
+
+// ActionEvent records one executed action for later inspection via (*Server).ActionLog or
+// (*Server).TailActions.
+type ActionEvent struct {
+	ActionDigest    digest.Digest
+	CommandDigest   digest.Digest
+	InputRootDigest digest.Digest
+	OutputDigests   []digest.Digest
+	Started         time.Time
+	Finished        time.Time
+	Code            codes.Code
+}
+
+// actionLog is a single-writer, many-reader append-only log of ActionEvents, modeled on the
+// livelog pattern used elsewhere for build output streaming: each tailer walks the log from its
+// own read offset under a condition variable, so a slow or stalled tailer can never cause the
+// writer to block or drop an event.
+type actionLog struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []ActionEvent
+	// gen is bumped by clear so an in-flight tailer notices its read offset no longer makes
+	// sense against the truncated events slice and resets it, instead of sitting stuck until
+	// enough new events accumulate to pass its stale offset.
+	gen int64
+}
+
+func newActionLog() *actionLog {
+	l := &actionLog{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *actionLog) append(e ActionEvent) {
+	l.mu.Lock()
+	l.events = append(l.events, e)
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *actionLog) snapshot() []ActionEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]ActionEvent, len(l.events))
+	copy(out, l.events)
+	return out
+}
+
+func (l *actionLog) clear() {
+	l.mu.Lock()
+	l.events = nil
+	l.gen++
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// tail streams every event already in the log plus every future one to the returned channel,
+// until ctx is done. Each call gets its own goroutine and read offset, so any number of tailers
+// can run concurrently without interfering with each other or with append.
+func (l *actionLog) tail(ctx context.Context) <-chan ActionEvent {
+	ch := make(chan ActionEvent)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Taking the lock orders this wakeup after the tailer's ctx.Err() check, so it
+			// cannot be lost between that check and cond.Wait.
+			l.mu.Lock()
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	go func() {
+		defer close(ch)
+		defer close(stop)
+		l.mu.Lock()
+		idx, gen := 0, l.gen
+		l.mu.Unlock()
+		for {
+			l.mu.Lock()
+			for idx >= len(l.events) && gen == l.gen && ctx.Err() == nil {
+				l.cond.Wait()
+			}
+			if ctx.Err() != nil {
+				l.mu.Unlock()
+				return
+			}
+			if gen != l.gen {
+				// clear() truncated the log out from under us; resume from the start of
+				// whatever it now contains instead of waiting forever on a stale offset.
+				idx, gen = 0, l.gen
+			}
+			pending := append([]ActionEvent(nil), l.events[idx:]...)
+			idx = len(l.events)
+			l.mu.Unlock()
+			for _, e := range pending {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// resolveAction best-effort looks up the Action blob for dg in the CAS so the log can record its
+// resolved command and input-root digests. It is normal for this to miss in tests that drive
+// Exec directly without going through the full upload path, in which case the zero digests are
+// recorded instead.
+func (e *Exec) resolveAction(dg digest.Digest) (cmdDg, rootDg digest.Digest) {
+	blob, ok := e.cas.Get(dg)
+	if !ok {
+		return digest.Digest{}, digest.Digest{}
+	}
+	ac := &repb.Action{}
+	if err := proto.Unmarshal(blob, ac); err != nil {
+		return digest.Digest{}, digest.Digest{}
+	}
+	cmdDg, _ = digest.NewFromProto(ac.CommandDigest)
+	rootDg, _ = digest.NewFromProto(ac.InputRootDigest)
+	return cmdDg, rootDg
+}
+
+// outputDigests collects the digests of every output the ActionResult references, for the
+// action log.
+func outputDigests(ar *repb.ActionResult) []digest.Digest {
+	if ar == nil {
+		return nil
+	}
+	var out []digest.Digest
+	for _, f := range ar.OutputFiles {
+		if dg, err := digest.NewFromProto(f.Digest); err == nil {
+			out = append(out, dg)
+		}
+	}
+	if ar.StdoutDigest != nil {
+		if dg, err := digest.NewFromProto(ar.StdoutDigest); err == nil {
+			out = append(out, dg)
+		}
+	}
+	if ar.StderrDigest != nil {
+		if dg, err := digest.NewFromProto(ar.StderrDigest); err == nil {
+			out = append(out, dg)
+		}
+	}
+	return out
+}
+
+// ActionLog returns a snapshot of every action executed by this server's default instance so
+// far. Use (*InstanceState).ActionLog to inspect a secondary instance.
+func (s *Server) ActionLog() []ActionEvent {
+	return s.Exec.log.snapshot()
+}
+
+// TailActions returns a channel that receives every action executed by this server's default
+// instance so far and every one executed in the future, until ctx is done. Any number of callers
+// may tail concurrently. Use (*InstanceState).TailActions to tail a secondary instance.
+func (s *Server) TailActions(ctx context.Context) <-chan ActionEvent {
+	return s.Exec.log.tail(ctx)
+}
+
+// ActionLog returns a snapshot of every action executed on this instance so far.
+func (inst *InstanceState) ActionLog() []ActionEvent {
+	return inst.Exec.log.snapshot()
+}
+
+// TailActions returns a channel that receives every action executed on this instance so far and
+// every one executed in the future, until ctx is done. Any number of callers may tail
+// concurrently.
+func (inst *InstanceState) TailActions(ctx context.Context) <-chan ActionEvent {
+	return inst.Exec.log.tail(ctx)
+}
@@ -0,0 +1,83 @@
+package fakes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"google.golang.org/grpc/codes"
+)
+
+func TestActionLogSnapshot(t *testing.T) {
+	l := newActionLog()
+	if got := l.snapshot(); len(got) != 0 {
+		t.Fatalf("snapshot() = %v, want empty", got)
+	}
+	e := ActionEvent{ActionDigest: digest.NewFromBlob([]byte("a")), Code: codes.OK}
+	l.append(e)
+	got := l.snapshot()
+	if len(got) != 1 || !reflect.DeepEqual(got[0], e) {
+		t.Fatalf("snapshot() = %v, want [%v]", got, e)
+	}
+}
+
+func TestActionLogTailReceivesPastAndFutureEvents(t *testing.T) {
+	l := newActionLog()
+	first := ActionEvent{ActionDigest: digest.NewFromBlob([]byte("first"))}
+	l.append(first)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := l.tail(ctx)
+
+	if got := <-ch; !reflect.DeepEqual(got, first) {
+		t.Fatalf("tail() first event = %v, want %v", got, first)
+	}
+
+	second := ActionEvent{ActionDigest: digest.NewFromBlob([]byte("second"))}
+	l.append(second)
+	select {
+	case got := <-ch:
+		if !reflect.DeepEqual(got, second) {
+			t.Fatalf("tail() second event = %v, want %v", got, second)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event appended after tail started")
+	}
+}
+
+// TestActionLogClearResyncsActiveTailer guards against a tailer getting stuck on a read offset
+// left over from before a clear(): it must resume from the truncated log rather than waiting
+// forever for enough new events to pass its stale offset.
+func TestActionLogClearResyncsActiveTailer(t *testing.T) {
+	l := newActionLog()
+	for i := 0; i < 5; i++ {
+		l.append(ActionEvent{ActionDigest: digest.NewFromBlob([]byte{byte(i)})})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := l.tail(ctx)
+	for i := 0; i < 5; i++ {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for pre-existing event %d", i)
+		}
+	}
+
+	l.clear()
+	next := ActionEvent{ActionDigest: digest.NewFromBlob([]byte("after-clear"))}
+	l.append(next)
+
+	select {
+	case got := <-ch:
+		if !reflect.DeepEqual(got, next) {
+			t.Fatalf("tail() event after clear = %v, want %v", got, next)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tailer never resumed after clear(); it is stuck on a stale offset")
+	}
+}
@@ -0,0 +1,418 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes/cas"
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CAS is a fake content-addressable storage for use in integration tests. It implements both
+// the ContentAddressableStorage and ByteStream services. Blob storage is delegated to a
+// cas.Backend, which defaults to an in-memory store but can be swapped via WithCASBackend for
+// tests that push more data than comfortably fits in RAM.
+type CAS struct {
+	backend cas.Backend
+
+	mu sync.Mutex
+	// compressed caches the zstd-compressed variant of blobs, computed lazily unless
+	// PutCompressed precomputed it. Always kept in memory regardless of backend, since it is a
+	// derived cache rather than the blob store of record.
+	compressed map[digest.Digest][]byte
+	// Compression, when true, makes the fake advertise zstd support in GetCapabilities and
+	// accept/serve the compressed-blobs/zstd bytestream scheme and compressed batch requests.
+	Compression bool
+
+	// FindMissingBlobsFault, BatchUpdateBlobsFault, BatchReadBlobsFault, and WriteFault inject
+	// latency and/or errors into the corresponding RPC. Nil injects nothing.
+	FindMissingBlobsFault *CallFault
+	BatchUpdateBlobsFault *CallFault
+	BatchReadBlobsFault   *CallFault
+	WriteFault            *CallFault
+
+	// MaxBatchTotalSize mirrors real servers rejecting an oversized BatchUpdateBlobs or
+	// BatchReadBlobs request with INVALID_ARGUMENT. Zero means unlimited, and is also what's
+	// reported as CacheCapabilities.MaxBatchTotalSizeBytes.
+	MaxBatchTotalSize int64
+	// MaxCasBlobSize rejects any single blob larger than it in FindMissingBlobs, BatchUpdateBlobs,
+	// and BatchReadBlobs with INVALID_ARGUMENT, forcing a well-behaved client to fall back to the
+	// bytestream API for it. Zero means unlimited.
+	MaxCasBlobSize int64
+
+	faults *faultInjector
+}
+
+// NewCAS returns a new empty fake CAS backed by an in-memory store.
+func NewCAS() *CAS {
+	return NewCASWithBackend(cas.NewMemBackend())
+}
+
+// NewCASWithBackend returns a new empty fake CAS backed by the given storage backend.
+func NewCASWithBackend(backend cas.Backend) *CAS {
+	return &CAS{backend: backend, compressed: make(map[digest.Digest][]byte), faults: newFaultInjector()}
+}
+
+// SetFlakyWrite configures the next bytestream Write(s) of dg to fail partway through as
+// described by fw, or clears any such configuration if fw is nil.
+func (c *CAS) SetFlakyWrite(dg digest.Digest, fw *FlakyWrite) {
+	c.faults.setFlakyWrite(dg, fw)
+}
+
+// Clear removes all content from the fake CAS, including its backend, and any configured flaky
+// writes. Configured faults and size limits on the CAS itself are left untouched, since those are
+// normally set once for a whole test rather than per Set call.
+func (c *CAS) Clear() {
+	c.mu.Lock()
+	c.compressed = make(map[digest.Digest][]byte)
+	c.mu.Unlock()
+	c.backend.Clear()
+	c.faults.clear()
+}
+
+// Backend returns the storage backend behind this CAS, so tests using a cas.DiskBackend can
+// Lease, Release, or Prune it directly.
+func (c *CAS) Backend() cas.Backend {
+	return c.backend
+}
+
+// Lease pins dg against eviction if the backend implements cas.Leaser (e.g. a cas.DiskBackend),
+// and is a no-op otherwise. TestEnv.Set leases every output it stages so it survives a Prune
+// call between Set calls, until released by the next Clear.
+func (c *CAS) Lease(dg digest.Digest) {
+	if l, ok := c.backend.(cas.Leaser); ok {
+		l.Lease(dg)
+	}
+}
+
+// Release undoes one Lease call for dg.
+func (c *CAS) Release(dg digest.Digest) {
+	if l, ok := c.backend.(cas.Leaser); ok {
+		l.Release(dg)
+	}
+}
+
+// Put adds a blob to the CAS and returns its digest.
+func (c *CAS) Put(blob []byte) digest.Digest {
+	dg := digest.NewFromBlob(blob)
+	c.backend.Put(dg, blob)
+	return dg
+}
+
+// Get returns the contents of the blob with the given digest, if present.
+func (c *CAS) Get(dg digest.Digest) ([]byte, bool) {
+	return c.backend.Get(dg)
+}
+
+// Contains reports whether the given digest is present in the fake CAS.
+func (c *CAS) Contains(dg digest.Digest) bool {
+	return c.backend.Contains(dg)
+}
+
+// FindMissingBlobs implements the CAS service.
+func (c *CAS) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	if err := c.FindMissingBlobsFault.inject(); err != nil {
+		return nil, err
+	}
+	resp := &repb.FindMissingBlobsResponse{}
+	for _, dgPb := range req.BlobDigests {
+		dg, err := digest.NewFromProto(dgPb)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid digest: %v", err)
+		}
+		if c.MaxCasBlobSize > 0 && dg.Size > c.MaxCasBlobSize {
+			return nil, status.Errorf(codes.InvalidArgument, "blob %v exceeds MaxCasBlobSize %d", dg, c.MaxCasBlobSize)
+		}
+		if !c.Contains(dg) {
+			resp.MissingBlobDigests = append(resp.MissingBlobDigests, dgPb)
+		}
+	}
+	return resp, nil
+}
+
+// BatchUpdateBlobs implements the CAS service. A request whose Compressor is ZSTD is decompressed
+// before being stored; this is only honored when the fake has Compression enabled.
+func (c *CAS) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	if err := c.BatchUpdateBlobsFault.inject(); err != nil {
+		return nil, err
+	}
+	if err := c.checkBatchSize(requestSizes(req.Requests)); err != nil {
+		return nil, err
+	}
+	resp := &repb.BatchUpdateBlobsResponse{}
+	for _, r := range req.Requests {
+		dg, err := digest.NewFromProto(r.Digest)
+		res := &repb.BatchUpdateBlobsResponse_Response{Digest: r.Digest}
+		if err == nil && c.MaxCasBlobSize > 0 && dg.Size > c.MaxCasBlobSize {
+			err = fmt.Errorf("blob %v exceeds MaxCasBlobSize %d", dg, c.MaxCasBlobSize)
+		}
+		data := r.Data
+		if err == nil && r.Compressor == repb.Compressor_ZSTD {
+			if !c.Compression {
+				err = fmt.Errorf("compressed uploads require Compression to be enabled on this fake")
+			} else {
+				data, err = zstdDecompress(r.Data)
+			}
+		} else if err == nil && r.Compressor != repb.Compressor_IDENTITY {
+			err = fmt.Errorf("compressor %v not supported by this fake", r.Compressor)
+		}
+		if err != nil {
+			res.Status = status.New(codes.InvalidArgument, err.Error()).Proto()
+		} else {
+			c.backend.Put(dg, data)
+			res.Status = status.New(codes.OK, "").Proto()
+		}
+		resp.Responses = append(resp.Responses, res)
+	}
+	return resp, nil
+}
+
+// BatchReadBlobs implements the CAS service. When the fake has Compression enabled and the
+// request lists ZSTD among its acceptable compressors, responses are returned zstd-compressed.
+func (c *CAS) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	if err := c.BatchReadBlobsFault.inject(); err != nil {
+		return nil, err
+	}
+	if err := c.checkBatchSize(digestSizes(req.Digests)); err != nil {
+		return nil, err
+	}
+	wantCompressed := c.Compression && compressorAccepted(req.AcceptableCompressors, repb.Compressor_ZSTD)
+	resp := &repb.BatchReadBlobsResponse{}
+	for _, dgPb := range req.Digests {
+		dg, err := digest.NewFromProto(dgPb)
+		res := &repb.BatchReadBlobsResponse_Response{Digest: dgPb}
+		if err == nil && c.MaxCasBlobSize > 0 && dg.Size > c.MaxCasBlobSize {
+			err = fmt.Errorf("blob %v exceeds MaxCasBlobSize %d", dg, c.MaxCasBlobSize)
+		}
+		if err != nil {
+			res.Status = status.New(codes.InvalidArgument, err.Error()).Proto()
+		} else if blob, ok := c.Get(dg); ok {
+			if wantCompressed {
+				res.Data = c.compressedVariant(dg, blob)
+				res.Compressor = repb.Compressor_ZSTD
+			} else {
+				res.Data = blob
+			}
+			res.Status = status.New(codes.OK, "").Proto()
+		} else {
+			res.Status = status.New(codes.NotFound, "blob not found").Proto()
+		}
+		resp.Responses = append(resp.Responses, res)
+	}
+	return resp, nil
+}
+
+// checkBatchSize returns an INVALID_ARGUMENT error if the sum of sizes exceeds MaxBatchTotalSize,
+// mirroring how a real server rejects an oversized BatchUpdateBlobs or BatchReadBlobs request
+// outright rather than failing individual items.
+func (c *CAS) checkBatchSize(sizes []int64) error {
+	if c.MaxBatchTotalSize <= 0 {
+		return nil
+	}
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	if total > c.MaxBatchTotalSize {
+		return status.Errorf(codes.InvalidArgument, "batch of %d bytes exceeds MaxBatchTotalSize %d", total, c.MaxBatchTotalSize)
+	}
+	return nil
+}
+
+func requestSizes(reqs []*repb.BatchUpdateBlobsRequest_Request) []int64 {
+	sizes := make([]int64, len(reqs))
+	for i, r := range reqs {
+		sizes[i] = int64(len(r.Data))
+	}
+	return sizes
+}
+
+func digestSizes(dgs []*repb.Digest) []int64 {
+	sizes := make([]int64, len(dgs))
+	for i, dg := range dgs {
+		sizes[i] = dg.SizeBytes
+	}
+	return sizes
+}
+
+func compressorAccepted(accepted []repb.Compressor_Value, want repb.Compressor_Value) bool {
+	for _, c := range accepted {
+		if c == want {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTree implements the CAS service. The fake does not support pagination.
+func (c *CAS) GetTree(req *repb.GetTreeRequest, stream regrpc.ContentAddressableStorage_GetTreeServer) error {
+	return status.Error(codes.Unimplemented, "GetTree is not implemented by the fake CAS")
+}
+
+// GetCapabilities implements the Capabilities service.
+func (c *CAS) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	cc := &repb.CacheCapabilities{
+		DigestFunction: []repb.DigestFunction_Value{repb.DigestFunction_SHA256},
+		ActionCacheUpdateCapabilities: &repb.ActionCacheUpdateCapabilities{
+			UpdateEnabled: true,
+		},
+		MaxBatchTotalSizeBytes: c.MaxBatchTotalSize,
+	}
+	if c.Compression {
+		cc.SupportedCompressors = []repb.Compressor_Value{repb.Compressor_IDENTITY, repb.Compressor_ZSTD}
+	}
+	return &repb.ServerCapabilities{CacheCapabilities: cc}, nil
+}
+
+// Read implements the ByteStream service, serving both the "blobs/{hash}/{size}" and, when
+// Compression is enabled, the "compressed-blobs/zstd/{hash}/{size}" resource schemes.
+func (c *CAS) Read(req *bsgrpc.ReadRequest, stream bsgrpc.ByteStream_ReadServer) error {
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if rn.compressor == repb.Compressor_ZSTD && !c.Compression {
+		return status.Errorf(codes.InvalidArgument, "compressed reads require Compression to be enabled on this fake")
+	}
+	// The compressed variant is always served out of the in-memory cache, since it's computed
+	// on demand rather than stored by the backend; everything else streams straight from it so
+	// large blobs are never materialized in full.
+	if rn.compressor == repb.Compressor_ZSTD {
+		blob, ok := c.Get(rn.digest)
+		if !ok {
+			return status.Errorf(codes.NotFound, "blob %v not found", rn.digest)
+		}
+		return sendChunks(stream, c.compressedVariant(rn.digest, blob))
+	}
+	r, ok := c.backend.Reader(rn.digest)
+	if !ok {
+		return status.Errorf(codes.NotFound, "blob %v not found", rn.digest)
+	}
+	defer r.Close()
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if sendErr := stream.Send(&bsgrpc.ReadResponse{Data: buf[:n]}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func sendChunks(stream bsgrpc.ByteStream_ReadServer, blob []byte) error {
+	const chunkSize = 1024 * 1024
+	for off := 0; off < len(blob); off += chunkSize {
+		end := off + chunkSize
+		if end > len(blob) {
+			end = len(blob)
+		}
+		if err := stream.Send(&bsgrpc.ReadResponse{Data: blob[off:end]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements the ByteStream service, accepting both the uncompressed and, when
+// Compression is enabled, the compressed-blobs/zstd resource schemes. Uncompressed writes are
+// streamed straight to the backend so large uploads are never buffered in full; compressed
+// writes must still be buffered, since decompressing a zstd frame needs it whole.
+func (c *CAS) Write(stream bsgrpc.ByteStream_WriteServer) error {
+	if err := c.WriteFault.inject(); err != nil {
+		return err
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if rn.compressor == repb.Compressor_ZSTD && !c.Compression {
+		return status.Errorf(codes.InvalidArgument, "compressed uploads require Compression to be enabled on this fake")
+	}
+
+	var committed int64
+	if rn.compressor == repb.Compressor_ZSTD {
+		data := append([]byte(nil), req.Data...)
+		committed = int64(len(data))
+		if flakeErr := c.faults.shouldFailAt(rn.digest, committed); flakeErr != nil {
+			return flakeErr
+		}
+		for !req.FinishWrite {
+			if req, err = stream.Recv(); err != nil {
+				return err
+			}
+			data = append(data, req.Data...)
+			committed = int64(len(data))
+			if flakeErr := c.faults.shouldFailAt(rn.digest, committed); flakeErr != nil {
+				return flakeErr
+			}
+		}
+		raw, err := zstdDecompress(data)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid zstd payload: %v", err)
+		}
+		c.backend.Put(rn.digest, raw)
+		c.mu.Lock()
+		c.compressed[rn.digest] = data
+		c.mu.Unlock()
+		return stream.SendAndClose(&bsgrpc.WriteResponse{CommittedSize: committed})
+	}
+
+	w, err := c.backend.Writer(rn.digest)
+	if err != nil {
+		return status.Errorf(codes.Internal, "opening backend writer: %v", err)
+	}
+	for {
+		n, werr := w.Write(req.Data)
+		committed += int64(n)
+		if werr != nil {
+			w.Abort()
+			return status.Errorf(codes.Internal, "writing blob: %v", werr)
+		}
+		if flakeErr := c.faults.shouldFailAt(rn.digest, committed); flakeErr != nil {
+			w.Abort()
+			return flakeErr
+		}
+		if req.FinishWrite {
+			break
+		}
+		if req, err = stream.Recv(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return status.Errorf(codes.Internal, "closing backend writer: %v", err)
+	}
+	return stream.SendAndClose(&bsgrpc.WriteResponse{CommittedSize: committed})
+}
+
+// QueryWriteStatus implements the ByteStream service. The fake treats every write as atomic, so
+// there is never a partial write to resume.
+func (c *CAS) QueryWriteStatus(ctx context.Context, req *bsgrpc.QueryWriteStatusRequest) (*bsgrpc.QueryWriteStatusResponse, error) {
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if c.Contains(rn.digest) {
+		return &bsgrpc.QueryWriteStatusResponse{CommittedSize: rn.digest.Size, Complete: true}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no write in progress for %v", rn.digest)
+}
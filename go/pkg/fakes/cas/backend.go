@@ -0,0 +1,131 @@
+// Package cas defines pluggable storage backends for fakes.CAS, so integration tests can choose
+// between an in-memory store and a disk-backed one without the gRPC-facing fake caring which.
+package cas
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+)
+
+// Backend stores and serves content-addressed blobs for a fake CAS.
+type Backend interface {
+	// Get returns the full contents of the blob with the given digest, if present.
+	Get(dg digest.Digest) ([]byte, bool)
+	// Put stores blob under dg, which the caller has already verified matches its contents.
+	Put(dg digest.Digest, blob []byte)
+	// Contains reports whether dg is present, without the cost of reading its contents.
+	Contains(dg digest.Digest) bool
+	// Reader opens a streaming reader for the blob with the given digest, if present, so large
+	// blobs need not be materialized in memory all at once.
+	Reader(dg digest.Digest) (io.ReadCloser, bool)
+	// Writer opens a streaming writer for dg. The backend trusts the caller to call Close only
+	// after writing exactly dg.Size bytes; a caller that fails or gives up partway through must
+	// call Abort instead, so the backend never registers a digest against partial content.
+	Writer(dg digest.Digest) (Writer, error)
+	// Clear removes every blob from the backend.
+	Clear()
+}
+
+// Leaser is implemented by backends that support pinning a blob against eviction, such as
+// DiskBackend's LRU eviction. Not every Backend needs to implement it; callers that want to
+// lease should type-assert for it and treat its absence as "this backend never evicts anyway".
+type Leaser interface {
+	// Lease pins dg so it is never evicted until a matching Release. Leases nest: a digest leased
+	// twice needs two Releases before it becomes evictable again.
+	Lease(dg digest.Digest)
+	// Release undoes one Lease call for dg.
+	Release(dg digest.Digest)
+}
+
+// Writer is a streaming write of a single blob in progress.
+type Writer interface {
+	io.Writer
+	// Close commits the written bytes under the blob's digest. The backend trusts the caller to
+	// call this only after writing exactly dg.Size bytes.
+	Close() error
+	// Abort discards whatever was written so far instead of committing it, e.g. because the
+	// write failed or was cancelled partway through.
+	Abort() error
+}
+
+// MemBackend is the default Backend: blobs are kept entirely in memory, same as the original
+// fake CAS behavior.
+type MemBackend struct {
+	mu    sync.RWMutex
+	blobs map[digest.Digest][]byte
+}
+
+// NewMemBackend returns a new empty in-memory Backend.
+func NewMemBackend() *MemBackend {
+	return &MemBackend{blobs: make(map[digest.Digest][]byte)}
+}
+
+// Get implements Backend.
+func (m *MemBackend) Get(dg digest.Digest) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[dg]
+	return blob, ok
+}
+
+// Put implements Backend.
+func (m *MemBackend) Put(dg digest.Digest, blob []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[dg] = blob
+}
+
+// Contains implements Backend.
+func (m *MemBackend) Contains(dg digest.Digest) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.blobs[dg]
+	return ok
+}
+
+// Reader implements Backend.
+func (m *MemBackend) Reader(dg digest.Digest) (io.ReadCloser, bool) {
+	blob, ok := m.Get(dg)
+	if !ok {
+		return nil, false
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), true
+}
+
+// Writer implements Backend.
+func (m *MemBackend) Writer(dg digest.Digest) (Writer, error) {
+	return &memWriter{dg: dg, backend: m}, nil
+}
+
+// Clear implements Backend.
+func (m *MemBackend) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs = make(map[digest.Digest][]byte)
+}
+
+type memWriter struct {
+	dg      digest.Digest
+	backend *MemBackend
+	buf     []byte
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *memWriter) Close() error {
+	w.backend.Put(w.dg, w.buf)
+	return nil
+}
+
+// Abort implements Writer by simply dropping the buffered bytes instead of storing them.
+func (w *memWriter) Abort() error {
+	w.buf = nil
+	return nil
+}
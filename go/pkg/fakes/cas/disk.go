@@ -0,0 +1,221 @@
+package cas
+
+import (
+	"container/list"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+)
+
+// DiskBackend is a Backend that stores blobs as "sha256/{hex}" files under a directory, evicting
+// the least-recently-used blob once the total size of stored blobs would exceed MaxBytes. Blobs
+// referenced by a Lease are never evicted, so a test holding the inputs/outputs of an
+// ActionResult alive won't have them disappear out from under it between Set calls.
+type DiskBackend struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	size     int64
+	order    *list.List // of digest.Digest, most-recently-used at the back
+	elems    map[digest.Digest]*list.Element
+	leases   map[digest.Digest]int
+}
+
+// NewDiskBackend returns a Backend that stores blobs under dir, which must already exist. A
+// maxBytes of zero means no eviction is performed.
+func NewDiskBackend(dir string, maxBytes int64) *DiskBackend {
+	return &DiskBackend{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		elems:    make(map[digest.Digest]*list.Element),
+		leases:   make(map[digest.Digest]int),
+	}
+}
+
+func (d *DiskBackend) path(dg digest.Digest) string {
+	return filepath.Join(d.dir, "sha256", dg.Hash)
+}
+
+// touch marks dg as most-recently-used. Caller holds d.mu.
+func (d *DiskBackend) touch(dg digest.Digest) {
+	if e, ok := d.elems[dg]; ok {
+		d.order.MoveToBack(e)
+		return
+	}
+	d.elems[dg] = d.order.PushBack(dg)
+}
+
+// Get implements Backend.
+func (d *DiskBackend) Get(dg digest.Digest) ([]byte, bool) {
+	blob, err := ioutil.ReadFile(d.path(dg))
+	if err != nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	d.touch(dg)
+	d.mu.Unlock()
+	return blob, true
+}
+
+// Put implements Backend.
+func (d *DiskBackend) Put(dg digest.Digest, blob []byte) {
+	if err := os.MkdirAll(filepath.Dir(d.path(dg)), 0755); err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(d.path(dg), blob, 0644); err != nil {
+		return
+	}
+	d.mu.Lock()
+	if _, exists := d.elems[dg]; !exists {
+		d.size += dg.Size
+	}
+	d.touch(dg)
+	d.mu.Unlock()
+	d.evict()
+}
+
+// Contains implements Backend.
+func (d *DiskBackend) Contains(dg digest.Digest) bool {
+	_, err := os.Stat(d.path(dg))
+	return err == nil
+}
+
+// Reader implements Backend.
+func (d *DiskBackend) Reader(dg digest.Digest) (io.ReadCloser, bool) {
+	f, err := os.Open(d.path(dg))
+	if err != nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	d.touch(dg)
+	d.mu.Unlock()
+	return f, true
+}
+
+// Writer implements Backend.
+func (d *DiskBackend) Writer(dg digest.Digest) (Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(d.path(dg)), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(d.path(dg))
+	if err != nil {
+		return nil, err
+	}
+	return &diskWriter{f: f, dg: dg, backend: d}, nil
+}
+
+type diskWriter struct {
+	f       *os.File
+	dg      digest.Digest
+	backend *DiskBackend
+}
+
+func (w *diskWriter) Write(p []byte) (int, error) { return w.f.Write(p) }
+
+func (w *diskWriter) Close() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.backend.mu.Lock()
+	if _, exists := w.backend.elems[w.dg]; !exists {
+		w.backend.size += w.dg.Size
+	}
+	w.backend.touch(w.dg)
+	w.backend.mu.Unlock()
+	w.backend.evict()
+	return nil
+}
+
+// Abort implements Writer by closing and deleting the partial file instead of registering it,
+// so a write that failed or was cancelled partway through never becomes a corrupt blob served
+// under its digest.
+func (w *diskWriter) Abort() error {
+	err := w.f.Close()
+	os.Remove(w.backend.path(w.dg))
+	return err
+}
+
+// Lease implements Leaser, pinning the given digest so it is never evicted until a matching
+// Release. Leases nest: a blob leased twice needs two Releases before it becomes evictable
+// again. fakes.CAS.Lease uses this to keep the blobs an ActionResult references alive across
+// Prune calls that simulate cache pressure on everything else.
+func (d *DiskBackend) Lease(dg digest.Digest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.leases[dg]++
+}
+
+// Release implements Leaser, undoing one Lease call for dg.
+func (d *DiskBackend) Release(dg digest.Digest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.leases[dg] <= 1 {
+		delete(d.leases, dg)
+		return
+	}
+	d.leases[dg]--
+}
+
+// Clear removes every blob from the backend, including leased ones, and resets all leases.
+func (d *DiskBackend) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for e := d.order.Front(); e != nil; e = e.Next() {
+		os.Remove(d.path(e.Value.(digest.Digest)))
+	}
+	d.order = list.New()
+	d.elems = make(map[digest.Digest]*list.Element)
+	d.leases = make(map[digest.Digest]int)
+	d.size = 0
+}
+
+// Prune evicts every unleased blob, regardless of MaxBytes, so tests can call it to simulate
+// cache pressure between fakes.TestEnv.Set calls. Leased blobs are kept.
+func (d *DiskBackend) Prune() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for e := d.oldestEvictableLocked(); e != nil; e = d.oldestEvictableLocked() {
+		dg := e.Value.(digest.Digest)
+		d.order.Remove(e)
+		delete(d.elems, dg)
+		d.size -= dg.Size
+		os.Remove(d.path(dg))
+	}
+}
+
+// evict removes least-recently-used, unleased blobs until size is back under maxBytes (or there
+// is nothing left that can be evicted).
+func (d *DiskBackend) evict() {
+	if d.maxBytes <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.size > d.maxBytes {
+		e := d.oldestEvictableLocked()
+		if e == nil {
+			return
+		}
+		dg := e.Value.(digest.Digest)
+		d.order.Remove(e)
+		delete(d.elems, dg)
+		d.size -= dg.Size
+		os.Remove(d.path(dg))
+	}
+}
+
+func (d *DiskBackend) oldestEvictableLocked() *list.Element {
+	for e := d.order.Front(); e != nil; e = e.Next() {
+		dg := e.Value.(digest.Digest)
+		if d.leases[dg] == 0 {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package cas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+)
+
+func newTestDiskBackend(t *testing.T, maxBytes int64) *DiskBackend {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "disk_backend_test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewDiskBackend(dir, maxBytes)
+}
+
+func TestDiskBackendPutGetContains(t *testing.T) {
+	d := newTestDiskBackend(t, 0)
+	dg := digest.NewFromBlob([]byte("hello"))
+	if d.Contains(dg) {
+		t.Fatalf("Contains(%v) = true before Put", dg)
+	}
+	d.Put(dg, []byte("hello"))
+	if !d.Contains(dg) {
+		t.Fatalf("Contains(%v) = false after Put", dg)
+	}
+	blob, ok := d.Get(dg)
+	if !ok || string(blob) != "hello" {
+		t.Fatalf("Get(%v) = %q, %v, want %q, true", dg, blob, ok, "hello")
+	}
+}
+
+func TestDiskBackendEvictsLeastRecentlyUsed(t *testing.T) {
+	a := digest.NewFromBlob([]byte("aaaa"))
+	b := digest.NewFromBlob([]byte("bbbb"))
+	d := newTestDiskBackend(t, a.Size+1) // only one blob fits at a time
+
+	d.Put(a, []byte("aaaa"))
+	d.Put(b, []byte("bbbb"))
+
+	if d.Contains(a) {
+		t.Errorf("Contains(a) = true, want evicted after b was stored over MaxBytes")
+	}
+	if !d.Contains(b) {
+		t.Errorf("Contains(b) = false, want present as the most recently stored blob")
+	}
+}
+
+func TestDiskBackendLeaseProtectsFromEviction(t *testing.T) {
+	a := digest.NewFromBlob([]byte("aaaa"))
+	b := digest.NewFromBlob([]byte("bbbb"))
+	d := newTestDiskBackend(t, a.Size+1)
+
+	d.Put(a, []byte("aaaa"))
+	d.Lease(a)
+	d.Put(b, []byte("bbbb"))
+
+	if !d.Contains(a) {
+		t.Errorf("Contains(a) = false, want leased blob to survive eviction pressure")
+	}
+
+	d.Release(a)
+	d.Prune()
+	if d.Contains(a) {
+		t.Errorf("Contains(a) = true, want evictable again once released and pruned")
+	}
+}
+
+func TestDiskBackendWriterAbortDiscardsPartialWrite(t *testing.T) {
+	d := newTestDiskBackend(t, 0)
+	dg := digest.NewFromBlob([]byte("hello"))
+
+	w, err := d.Writer(dg)
+	if err != nil {
+		t.Fatalf("Writer(%v): %v", dg, err)
+	}
+	if _, err := w.Write([]byte("hel")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+
+	if d.Contains(dg) {
+		t.Errorf("Contains(%v) = true after Abort, want the partial write discarded", dg)
+	}
+	if _, ok := d.Get(dg); ok {
+		t.Errorf("Get(%v) succeeded after Abort, want the partial file removed", dg)
+	}
+}
+
+func TestDiskBackendWriterCloseCommits(t *testing.T) {
+	d := newTestDiskBackend(t, 0)
+	dg := digest.NewFromBlob([]byte("hello"))
+
+	w, err := d.Writer(dg)
+	if err != nil {
+		t.Fatalf("Writer(%v): %v", dg, err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	blob, ok := d.Get(dg)
+	if !ok || string(blob) != "hello" {
+		t.Fatalf("Get(%v) = %q, %v, want %q, true", dg, blob, ok, "hello")
+	}
+}
@@ -0,0 +1,111 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// fakeWriteStream replays a canned sequence of WriteRequests as a bsgrpc.ByteStream_WriteServer,
+// so CAS.Write can be driven without a real gRPC connection.
+type fakeWriteStream struct {
+	grpc.ServerStream
+	reqs []*bsgrpc.WriteRequest
+	idx  int
+	resp *bsgrpc.WriteResponse
+}
+
+func (s *fakeWriteStream) Recv() (*bsgrpc.WriteRequest, error) {
+	if s.idx >= len(s.reqs) {
+		return nil, io.EOF
+	}
+	req := s.reqs[s.idx]
+	s.idx++
+	return req, nil
+}
+
+func (s *fakeWriteStream) SendAndClose(resp *bsgrpc.WriteResponse) error {
+	s.resp = resp
+	return nil
+}
+
+func blobResourceName(blob []byte) (string, []byte) {
+	dg := digest.NewFromBlob(blob)
+	return fmt.Sprintf("instance/uploads/uuid/blobs/%s/%d", dg.Hash, dg.Size), blob
+}
+
+func TestCASWriteRoundTrip(t *testing.T) {
+	c := NewCAS()
+	blob := []byte("hello, fake CAS")
+	name, data := blobResourceName(blob)
+	stream := &fakeWriteStream{reqs: []*bsgrpc.WriteRequest{
+		{ResourceName: name, Data: data, FinishWrite: true},
+	}}
+
+	if err := c.Write(stream); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, ok := c.Get(digest.NewFromBlob(blob))
+	if !ok || string(got) != string(blob) {
+		t.Fatalf("Get after Write = %q, %v, want %q, true", got, ok, blob)
+	}
+}
+
+// TestCASWriteFlakyFailureDiscardsPartialBlob is the regression test for the corrupt-partial-
+// blob bug: a write that fails partway through must not leave the digest Contains()==true over
+// whatever bytes were received before the failure.
+func TestCASWriteFlakyFailureDiscardsPartialBlob(t *testing.T) {
+	c := NewCAS()
+	blob := []byte("hello, flaky fake CAS")
+	dg := digest.NewFromBlob(blob)
+	c.SetFlakyWrite(dg, &FlakyWrite{OffsetBytes: 3, Code: codes.Aborted, Message: "flaky"})
+
+	name, _ := blobResourceName(blob)
+	stream := &fakeWriteStream{reqs: []*bsgrpc.WriteRequest{
+		{ResourceName: name, Data: blob, FinishWrite: true},
+	}}
+
+	if err := c.Write(stream); err == nil {
+		t.Fatal("Write with a flaky write configured = nil error, want one")
+	}
+	if c.Contains(dg) {
+		t.Errorf("Contains(%v) = true after a failed write, want the partial blob discarded", dg)
+	}
+}
+
+func TestFindMissingBlobsEnforcesMaxCasBlobSize(t *testing.T) {
+	c := NewCAS()
+	c.MaxCasBlobSize = 2
+	dg := digest.NewFromBlob([]byte("too big"))
+	_, err := c.FindMissingBlobs(context.Background(), &repb.FindMissingBlobsRequest{
+		BlobDigests: []*repb.Digest{{Hash: dg.Hash, SizeBytes: dg.Size}},
+	})
+	if err == nil {
+		t.Fatal("FindMissingBlobs with a digest over MaxCasBlobSize = nil error, want INVALID_ARGUMENT")
+	}
+}
+
+func TestFindMissingBlobsReportsMissing(t *testing.T) {
+	c := NewCAS()
+	present := c.Put([]byte("present"))
+	missingDg := digest.NewFromBlob([]byte("missing"))
+	resp, err := c.FindMissingBlobs(context.Background(), &repb.FindMissingBlobsRequest{
+		BlobDigests: []*repb.Digest{
+			{Hash: present.Hash, SizeBytes: present.Size},
+			{Hash: missingDg.Hash, SizeBytes: missingDg.Size},
+		},
+	})
+	if err != nil {
+		t.Fatalf("FindMissingBlobs: %v", err)
+	}
+	if len(resp.MissingBlobDigests) != 1 || resp.MissingBlobDigests[0].Hash != missingDg.Hash {
+		t.Fatalf("MissingBlobDigests = %v, want only %v", resp.MissingBlobDigests, missingDg)
+	}
+}
@@ -0,0 +1,112 @@
+package fakes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// resourceName is a parsed bytestream resource name, covering both the uncompressed
+// "blobs/{hash}/{size}" scheme and the "compressed-blobs/zstd/{hash}/{size}" scheme used when
+// the fake has compression enabled.
+type resourceName struct {
+	instance   string
+	compressor repb.Compressor_Value
+	digest     digest.Digest
+}
+
+// parseResourceName extracts the instance name, digest, and compressor out of a bytestream
+// resource name. It tolerates the "uploads/{uuid}/" prefix real clients send between the
+// instance name and the blobs/compressed-blobs segment on a Write, stripping it back out of the
+// instance name rather than treating the per-upload UUID as part of it.
+func parseResourceName(name string) (resourceName, error) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		switch p {
+		case "blobs":
+			if i+2 >= len(parts) {
+				return resourceName{}, fmt.Errorf("malformed resource name %q", name)
+			}
+			dg, err := digestFromParts(parts[i+1], parts[i+2])
+			return resourceName{instance: instanceFromPrefix(parts[:i]), compressor: repb.Compressor_IDENTITY, digest: dg}, err
+		case "compressed-blobs":
+			if i+3 >= len(parts) {
+				return resourceName{}, fmt.Errorf("malformed resource name %q", name)
+			}
+			if parts[i+1] != "zstd" {
+				return resourceName{}, fmt.Errorf("unsupported compressor %q in resource name %q", parts[i+1], name)
+			}
+			dg, err := digestFromParts(parts[i+2], parts[i+3])
+			return resourceName{instance: instanceFromPrefix(parts[:i]), compressor: repb.Compressor_ZSTD, digest: dg}, err
+		}
+	}
+	return resourceName{}, fmt.Errorf("malformed resource name %q: missing blobs/compressed-blobs segment", name)
+}
+
+// instanceFromPrefix joins the resource-name segments preceding blobs/compressed-blobs back into
+// an instance name, dropping a trailing "uploads/{uuid}" pair if present so a Write's per-upload
+// UUID is never mistaken for (part of) the instance name.
+func instanceFromPrefix(parts []string) string {
+	if len(parts) >= 2 && parts[len(parts)-2] == "uploads" {
+		parts = parts[:len(parts)-2]
+	}
+	return strings.Join(parts, "/")
+}
+
+func digestFromParts(hash, sizeStr string) (digest.Digest, error) {
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return digest.Digest{}, fmt.Errorf("invalid size %q: %v", sizeStr, err)
+	}
+	return digest.New(hash, size)
+}
+
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+func zstdCompress(blob []byte) []byte {
+	return zstdEncoder.EncodeAll(blob, nil)
+}
+
+func zstdDecompress(blob []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(blob, nil)
+}
+
+// compressedVariant returns the zstd-compressed bytes for blob, computing and caching them on
+// first use.
+func (c *CAS) compressedVariant(dg digest.Digest, blob []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if z, ok := c.compressed[dg]; ok {
+		return z
+	}
+	z := zstdCompress(blob)
+	c.compressed[dg] = z
+	return z
+}
+
+// put adds a blob to the CAS, additionally precomputing its compressed variant when the CAS has
+// Compression enabled. Used by the fake action result builders (OutputFile, StdOut, StdErr) so
+// that enabling WithCompression on the Server is enough to exercise a client's compressed
+// download path without every test having to call PutCompressed directly.
+func (c *CAS) put(blob []byte) digest.Digest {
+	if c.Compression {
+		return c.PutCompressed(blob)
+	}
+	return c.Put(blob)
+}
+
+// PutCompressed adds a blob to the CAS, like Put, and additionally precomputes and caches its
+// zstd-compressed variant so that a client reading it via the compressed-blobs resource scheme
+// is served a pre-baked compressed payload rather than one compressed on demand. Tests use this
+// (through OutputFile, StdOut, and StdErr) to force a TestEnv's client down its compressed
+// download path.
+func (c *CAS) PutCompressed(blob []byte) digest.Digest {
+	dg := c.Put(blob)
+	c.compressedVariant(dg, blob)
+	return dg
+}
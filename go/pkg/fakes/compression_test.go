@@ -0,0 +1,84 @@
+package fakes
+
+import (
+	"testing"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+func TestZstdRoundTrip(t *testing.T) {
+	want := []byte("the quick brown fox jumps over the lazy dog")
+	compressed := zstdCompress(want)
+	got, err := zstdDecompress(compressed)
+	if err != nil {
+		t.Fatalf("zstdDecompress: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("zstdDecompress(zstdCompress(%q)) = %q", want, got)
+	}
+}
+
+func TestParseResourceNameUncompressed(t *testing.T) {
+	rn, err := parseResourceName("instance/uploads/uuid/blobs/deadbeef/4")
+	if err != nil {
+		t.Fatalf("parseResourceName: %v", err)
+	}
+	if rn.instance != "instance" {
+		t.Errorf("instance = %q, want %q (the uploads/uuid segment stripped out)", rn.instance, "instance")
+	}
+	if rn.compressor != repb.Compressor_IDENTITY {
+		t.Errorf("compressor = %v, want IDENTITY", rn.compressor)
+	}
+	if rn.digest.Hash != "deadbeef" || rn.digest.Size != 4 {
+		t.Errorf("digest = %+v, want {Hash: deadbeef, Size: 4}", rn.digest)
+	}
+}
+
+func TestParseResourceNameCompressed(t *testing.T) {
+	rn, err := parseResourceName("instance/uploads/uuid/compressed-blobs/zstd/deadbeef/4")
+	if err != nil {
+		t.Fatalf("parseResourceName: %v", err)
+	}
+	if rn.instance != "instance" {
+		t.Errorf("instance = %q, want %q (the uploads/uuid segment stripped out)", rn.instance, "instance")
+	}
+	if rn.compressor != repb.Compressor_ZSTD {
+		t.Errorf("compressor = %v, want ZSTD", rn.compressor)
+	}
+	if rn.digest.Hash != "deadbeef" || rn.digest.Size != 4 {
+		t.Errorf("digest = %+v, want {Hash: deadbeef, Size: 4}", rn.digest)
+	}
+}
+
+func TestParseResourceNameWriteWithNoInstance(t *testing.T) {
+	rn, err := parseResourceName("uploads/uuid/blobs/deadbeef/4")
+	if err != nil {
+		t.Fatalf("parseResourceName: %v", err)
+	}
+	if rn.instance != "" {
+		t.Errorf("instance = %q, want empty string for a write with no instance prefix", rn.instance)
+	}
+}
+
+func TestParseResourceNameRejectsUnknownCompressor(t *testing.T) {
+	if _, err := parseResourceName("instance/compressed-blobs/gzip/deadbeef/4"); err == nil {
+		t.Fatal("parseResourceName with an unsupported compressor scheme = nil error, want one")
+	}
+}
+
+func TestCASPutCompressedPrecomputesVariant(t *testing.T) {
+	c := NewCAS()
+	blob := []byte("hello, compressed world")
+	dg := c.PutCompressed(blob)
+
+	c.mu.Lock()
+	_, cached := c.compressed[dg]
+	c.mu.Unlock()
+	if !cached {
+		t.Errorf("compressed[%v] missing after PutCompressed, want precomputed", dg)
+	}
+	got, ok := c.Get(dg)
+	if !ok || string(got) != string(blob) {
+		t.Fatalf("Get(%v) = %q, %v, want %q, true", dg, got, ok, blob)
+	}
+}
@@ -0,0 +1,366 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExecDelays configures how long the fake spends in each stage of action execution, mirroring
+// the stages an ExecutedActionMetadata reports for a real worker. A zero duration skips the
+// corresponding sleep. The resulting stage durations are observable, not just felt as wall-clock
+// time: completedOp stamps them onto the returned ActionResult's ExecutionMetadata.
+type ExecDelays struct {
+	Queued          time.Duration
+	InputFetching   time.Duration
+	Executing       time.Duration
+	OutputUploading time.Duration
+}
+
+// FailureMode injects a canned failure into executed actions instead of returning the
+// configured ActionResult.
+type FailureMode struct {
+	// Code is the status code returned for the failing attempts.
+	Code codes.Code
+	// Message is the status message returned alongside Code.
+	Message string
+	// Attempts bounds how many consecutive executions fail before the fake reverts to
+	// succeeding; zero means fail indefinitely. Set this to simulate a transient error that a
+	// retrying client eventually gets past.
+	Attempts int
+}
+
+// Exec is a fake action executor for use in integration tests. By default it completes every
+// action synchronously and in full, but it can be configured with a bounded worker pool,
+// per-stage delays, and an injected failure mode to exercise client-side retry, backoff, and
+// long-poll WaitExecution behaviors that a synchronous fake cannot reach.
+type Exec struct {
+	ac  *ActionCache
+	cas *CAS
+	// instanceName is prefixed onto every operation name this Exec generates, so a Server
+	// hosting several instances can tell which one a WaitExecution request's operation belongs
+	// to without instance_name being part of WaitExecutionRequest itself.
+	instanceName string
+
+	mu sync.Mutex
+	// ActionResult is returned as the result of every execution while set this way.
+	ActionResult *repb.ActionResult
+	// Status, if non-nil and non-OK, is returned as the execution error instead of ActionResult.
+	Status *status.Status
+	// Cached marks the returned ActionResult as a cache hit.
+	Cached bool
+	// OutputBlobs accumulates the raw bytes of outputs staged into the CAS by option Apply
+	// calls, for test inspection.
+	OutputBlobs [][]byte
+	// leasedOutputs tracks the digests leased on behalf of outputs staged by option Apply calls,
+	// so Clear can release them again.
+	leasedOutputs []digest.Digest
+	// Concurrency bounds how many actions may be EXECUTING at once; additional actions queue
+	// until a slot frees up. Zero (the default) means unbounded concurrency.
+	Concurrency int
+	// Delays configures the simulated duration of each execution stage.
+	Delays ExecDelays
+	// Failure, while set, is injected into executions instead of the configured result.
+	Failure *FailureMode
+
+	sem      chan struct{}
+	failures int32
+
+	opsMu    sync.Mutex
+	ops      map[string]*operation
+	nextOpID int64
+
+	log *actionLog
+}
+
+// NewExec returns a new fake Exec backed by the given ActionCache and CAS, generating operation
+// names under the given instance name.
+func NewExec(ac *ActionCache, cas *CAS, instanceName string) *Exec {
+	return &Exec{ac: ac, cas: cas, instanceName: instanceName, ops: make(map[string]*operation), log: newActionLog()}
+}
+
+// Clear resets the fake executor to its zero configuration.
+func (e *Exec) Clear() {
+	e.mu.Lock()
+	e.ActionResult = nil
+	e.Status = nil
+	e.Cached = false
+	e.OutputBlobs = nil
+	e.Concurrency = 0
+	e.Delays = ExecDelays{}
+	e.Failure = nil
+	e.sem = nil
+	leased := e.leasedOutputs
+	e.leasedOutputs = nil
+	e.mu.Unlock()
+	for _, dg := range leased {
+		e.cas.Release(dg)
+	}
+	atomic.StoreInt32(&e.failures, 0)
+	e.opsMu.Lock()
+	e.ops = make(map[string]*operation)
+	e.opsMu.Unlock()
+	e.log.clear()
+}
+
+// leaseOutput pins dg in the CAS backend (if it supports leasing) on behalf of an output staged
+// by an option Apply call, and records it so Clear releases it again.
+func (e *Exec) leaseOutput(dg digest.Digest) {
+	e.cas.Lease(dg)
+	e.mu.Lock()
+	e.leasedOutputs = append(e.leasedOutputs, dg)
+	e.mu.Unlock()
+}
+
+// pool lazily builds the worker-pool semaphore for the currently configured concurrency.
+func (e *Exec) pool() chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Concurrency <= 0 {
+		return nil
+	}
+	if e.sem == nil || cap(e.sem) != e.Concurrency {
+		e.sem = make(chan struct{}, e.Concurrency)
+	}
+	return e.sem
+}
+
+// operation tracks the lifecycle of a single simulated execution so that WaitExecution can
+// reconnect to it and replay any stage the caller missed.
+type operation struct {
+	mu     sync.Mutex
+	latest *lpb.Operation
+	subs   []chan *lpb.Operation
+}
+
+func (o *operation) subscribe() chan *lpb.Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ch := make(chan *lpb.Operation, 4)
+	if o.latest != nil {
+		ch <- o.latest
+		if o.latest.Done {
+			close(ch)
+			return ch
+		}
+	}
+	o.subs = append(o.subs, ch)
+	return ch
+}
+
+func (o *operation) publish(op *lpb.Operation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.latest = op
+	for _, ch := range o.subs {
+		ch <- op
+		if op.Done {
+			close(ch)
+		}
+	}
+	if op.Done {
+		o.subs = nil
+	}
+}
+
+// Execute implements the Execution service.
+func (e *Exec) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_ExecuteServer) error {
+	dg, err := digest.NewFromProto(req.ActionDigest)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid action digest: %v", err)
+	}
+	e.opsMu.Lock()
+	e.nextOpID++
+	name := fmt.Sprintf("%s/operations/%d", e.instanceName, e.nextOpID)
+	op := &operation{}
+	e.ops[name] = op
+	e.opsMu.Unlock()
+
+	go e.run(name, dg, op)
+
+	return streamOperation(stream.Context(), op, stream)
+}
+
+// WaitExecution implements the Execution service, reconnecting to an in-flight or completed
+// execution started by Execute.
+func (e *Exec) WaitExecution(req *repb.WaitExecutionRequest, stream regrpc.Execution_WaitExecutionServer) error {
+	e.opsMu.Lock()
+	op, ok := e.ops[req.Name]
+	e.opsMu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "operation %q not found", req.Name)
+	}
+	return streamOperation(stream.Context(), op, stream)
+}
+
+// opSender is satisfied by both Execution_ExecuteServer and Execution_WaitExecutionServer.
+type opSender interface {
+	Send(*lpb.Operation) error
+}
+
+func streamOperation(ctx context.Context, op *operation, stream opSender) error {
+	ch := op.subscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case upd, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(upd); err != nil {
+				return err
+			}
+			if upd.Done {
+				return nil
+			}
+		}
+	}
+}
+
+// stageTimes records when each simulated execution stage started and finished, so completedOp
+// can populate ActionResult.ExecutionMetadata with real timestamps that make the configured
+// Delays observable to a client, rather than felt only as opaque wall-clock time.
+type stageTimes struct {
+	queued                          time.Time
+	inputFetchStart, inputFetchDone time.Time
+	execStart, execDone             time.Time
+	uploadStart, uploadDone         time.Time
+}
+
+// run drives a single simulated execution through QUEUED, EXECUTING, and COMPLETED, honoring
+// the configured delays, worker-pool concurrency cap, and failure mode, and records the result
+// in the action log.
+func (e *Exec) run(name string, dg digest.Digest, op *operation) {
+	started := time.Now()
+	var st stageTimes
+	st.queued = started
+	op.publish(e.stageOp(name, dg, repb.ExecutionStage_QUEUED))
+
+	e.mu.Lock()
+	delays := e.Delays
+	e.mu.Unlock()
+	sleep(delays.Queued)
+
+	if sem := e.pool(); sem != nil {
+		sem <- struct{}{}
+		defer func() { <-sem }()
+	}
+
+	st.inputFetchStart = time.Now()
+	sleep(delays.InputFetching)
+	st.inputFetchDone = time.Now()
+	op.publish(e.stageOp(name, dg, repb.ExecutionStage_EXECUTING))
+	st.execStart = st.inputFetchDone
+	sleep(delays.Executing)
+	st.execDone = time.Now()
+	st.uploadStart = st.execDone
+	sleep(delays.OutputUploading)
+	st.uploadDone = time.Now()
+
+	final := e.completedOp(name, dg, st)
+	op.publish(final)
+
+	cmdDg, rootDg := e.resolveAction(dg)
+	var ar *repb.ActionResult
+	code := codes.OK
+	switch res := final.Result.(type) {
+	case *lpb.Operation_Error:
+		code = codes.Code(res.Error.Code)
+	case *lpb.Operation_Response:
+		resp := &repb.ExecuteResponse{}
+		if err := ptypes.UnmarshalAny(res.Response, resp); err == nil {
+			ar = resp.Result
+			code = codes.Code(resp.Status.GetCode())
+		}
+	}
+	e.log.append(ActionEvent{
+		ActionDigest:    dg,
+		CommandDigest:   cmdDg,
+		InputRootDigest: rootDg,
+		OutputDigests:   outputDigests(ar),
+		Started:         started,
+		Finished:        time.Now(),
+		Code:            code,
+	})
+}
+
+// withExecutionMetadata returns ar with an ExecutedActionMetadata populated from stages, so a
+// client inspecting the result can observe that it actually waited through each configured
+// Delays stage rather than one opaque sleep. ar is left untouched (and a nil ar untouched as
+// nil) if it already carries metadata, on the assumption a test that set its own meant it.
+func withExecutionMetadata(ar *repb.ActionResult, stages stageTimes) *repb.ActionResult {
+	if ar == nil || ar.ExecutionMetadata != nil {
+		return ar
+	}
+	out := proto.Clone(ar).(*repb.ActionResult)
+	out.ExecutionMetadata = &repb.ExecutedActionMetadata{
+		QueuedTimestamp:                ts(stages.queued),
+		InputFetchStartTimestamp:       ts(stages.inputFetchStart),
+		InputFetchCompletedTimestamp:   ts(stages.inputFetchDone),
+		ExecutionStartTimestamp:        ts(stages.execStart),
+		ExecutionCompletedTimestamp:    ts(stages.execDone),
+		OutputUploadStartTimestamp:     ts(stages.uploadStart),
+		OutputUploadCompletedTimestamp: ts(stages.uploadDone),
+	}
+	return out
+}
+
+func ts(t time.Time) *tspb.Timestamp {
+	pb, _ := ptypes.TimestampProto(t)
+	return pb
+}
+
+func sleep(d time.Duration) {
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (e *Exec) stageOp(name string, dg digest.Digest, stage repb.ExecutionStage_Value) *lpb.Operation {
+	meta, _ := ptypes.MarshalAny(&repb.ExecuteOperationMetadata{
+		Stage:        stage,
+		ActionDigest: dg.ToProto(),
+	})
+	return &lpb.Operation{Name: name, Metadata: meta}
+}
+
+func (e *Exec) completedOp(name string, dg digest.Digest, stages stageTimes) *lpb.Operation {
+	op := e.stageOp(name, dg, repb.ExecutionStage_COMPLETED)
+	op.Done = true
+
+	e.mu.Lock()
+	ar, st, cached, fail := e.ActionResult, e.Status, e.Cached, e.Failure
+	e.mu.Unlock()
+
+	if fail != nil && (fail.Attempts == 0 || atomic.AddInt32(&e.failures, 1) <= int32(fail.Attempts)) {
+		op.Result = &lpb.Operation_Error{Error: status.New(fail.Code, fail.Message).Proto()}
+		return op
+	}
+	if st == nil {
+		st = status.New(codes.OK, "")
+	}
+	if st.Code() != codes.OK {
+		op.Result = &lpb.Operation_Error{Error: st.Proto()}
+		return op
+	}
+	resp, _ := ptypes.MarshalAny(&repb.ExecuteResponse{
+		Result: withExecutionMetadata(ar, stages),
+		Cached: cached,
+		Status: st.Proto(),
+	})
+	op.Result = &lpb.Operation_Response{Response: resp}
+	return op
+}
@@ -0,0 +1,132 @@
+package fakes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"github.com/golang/protobuf/ptypes"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	lpb "google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/grpc/codes"
+)
+
+func newTestExec() *Exec {
+	ac := NewActionCache()
+	c := NewCAS()
+	return NewExec(ac, c, defaultInstance)
+}
+
+func TestExecPoolNilWithoutConcurrencyLimit(t *testing.T) {
+	e := newTestExec()
+	if sem := e.pool(); sem != nil {
+		t.Errorf("pool() = %v, want nil when Concurrency is unset", sem)
+	}
+}
+
+func TestExecPoolSizedToConcurrency(t *testing.T) {
+	e := newTestExec()
+	e.Concurrency = 2
+	sem := e.pool()
+	if cap(sem) != 2 {
+		t.Fatalf("cap(pool()) = %d, want 2", cap(sem))
+	}
+	if e.pool() != sem {
+		t.Errorf("pool() returned a different channel on a second call with the same Concurrency")
+	}
+}
+
+func TestExecCompletedOpAppliesConfiguredResult(t *testing.T) {
+	e := newTestExec()
+	e.ActionResult = &repb.ActionResult{ExitCode: 7}
+	dg := digest.NewFromBlob([]byte("action"))
+
+	op := e.completedOp("instance/operations/1", dg, stageTimes{})
+	if _, ok := op.Result.(*lpb.Operation_Response); !ok {
+		t.Fatalf("completedOp().Result = %T, want *lpb.Operation_Response", op.Result)
+	}
+}
+
+func TestExecCompletedOpInjectsFailureUntilAttemptsExhausted(t *testing.T) {
+	e := newTestExec()
+	e.Failure = &FailureMode{Code: codes.Unavailable, Message: "down", Attempts: 2}
+	dg := digest.NewFromBlob([]byte("action"))
+
+	for i := 0; i < 2; i++ {
+		op := e.completedOp("instance/operations/1", dg, stageTimes{})
+		if _, ok := op.Result.(*lpb.Operation_Error); !ok {
+			t.Fatalf("attempt %d: completedOp().Result = %T, want *lpb.Operation_Error", i, op.Result)
+		}
+	}
+
+	op := e.completedOp("instance/operations/1", dg, stageTimes{})
+	if _, ok := op.Result.(*lpb.Operation_Response); !ok {
+		t.Fatalf("attempt 3: completedOp().Result = %T, want *lpb.Operation_Response once Attempts is exhausted", op.Result)
+	}
+}
+
+func TestExecCompletedOpPopulatesExecutionMetadata(t *testing.T) {
+	e := newTestExec()
+	e.ActionResult = &repb.ActionResult{ExitCode: 0}
+	dg := digest.NewFromBlob([]byte("action"))
+	now := time.Now()
+	stages := stageTimes{
+		queued:          now,
+		inputFetchStart: now.Add(1 * time.Millisecond),
+		inputFetchDone:  now.Add(2 * time.Millisecond),
+		execStart:       now.Add(2 * time.Millisecond),
+		execDone:        now.Add(3 * time.Millisecond),
+		uploadStart:     now.Add(3 * time.Millisecond),
+		uploadDone:      now.Add(4 * time.Millisecond),
+	}
+
+	op := e.completedOp("instance/operations/1", dg, stages)
+	resp, ok := op.Result.(*lpb.Operation_Response)
+	if !ok {
+		t.Fatalf("completedOp().Result = %T, want *lpb.Operation_Response", op.Result)
+	}
+	execResp := &repb.ExecuteResponse{}
+	if err := ptypes.UnmarshalAny(resp.Response, execResp); err != nil {
+		t.Fatalf("UnmarshalAny: %v", err)
+	}
+	meta := execResp.Result.GetExecutionMetadata()
+	if meta == nil {
+		t.Fatal("ExecutionMetadata = nil, want it populated from the stage delays")
+	}
+	if meta.QueuedTimestamp == nil || meta.InputFetchStartTimestamp == nil ||
+		meta.InputFetchCompletedTimestamp == nil || meta.ExecutionStartTimestamp == nil ||
+		meta.ExecutionCompletedTimestamp == nil || meta.OutputUploadStartTimestamp == nil ||
+		meta.OutputUploadCompletedTimestamp == nil {
+		t.Errorf("ExecutionMetadata = %+v, want every stage timestamp set", meta)
+	}
+}
+
+func TestExecCompletedOpLeavesExistingExecutionMetadataAlone(t *testing.T) {
+	e := newTestExec()
+	custom := &repb.ExecutedActionMetadata{Worker: "custom-worker"}
+	e.ActionResult = &repb.ActionResult{ExitCode: 0, ExecutionMetadata: custom}
+	dg := digest.NewFromBlob([]byte("action"))
+
+	op := e.completedOp("instance/operations/1", dg, stageTimes{queued: time.Now()})
+	resp := op.Result.(*lpb.Operation_Response)
+	execResp := &repb.ExecuteResponse{}
+	if err := ptypes.UnmarshalAny(resp.Response, execResp); err != nil {
+		t.Fatalf("UnmarshalAny: %v", err)
+	}
+	if got := execResp.Result.GetExecutionMetadata().GetWorker(); got != "custom-worker" {
+		t.Errorf("ExecutionMetadata.Worker = %q, want the test-supplied value left untouched", got)
+	}
+}
+
+func TestExecClearReleasesLeasedOutputs(t *testing.T) {
+	e := newTestExec()
+	dg := digest.NewFromBlob([]byte("output"))
+	e.leaseOutput(dg)
+	if len(e.leasedOutputs) != 1 {
+		t.Fatalf("leasedOutputs = %v, want one entry after leaseOutput", e.leasedOutputs)
+	}
+	e.Clear()
+	if len(e.leasedOutputs) != 0 {
+		t.Errorf("leasedOutputs = %v, want empty after Clear", e.leasedOutputs)
+	}
+}
@@ -0,0 +1,105 @@
+package fakes
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CallFault configures latency and error-probability injection for a single fake CAS RPC. A
+// nil *CallFault injects nothing.
+type CallFault struct {
+	// Delay is slept before the call proceeds.
+	Delay time.Duration
+	// ErrorRate is the probability, from 0 to 1, that the call fails with Code/Message instead
+	// of proceeding. 1 means it always fails.
+	ErrorRate float64
+	// Code is the status code returned when the injected error triggers.
+	Code codes.Code
+	// Message is the status message returned alongside Code.
+	Message string
+}
+
+// inject sleeps f.Delay and then, with probability f.ErrorRate, returns the configured error.
+func (f *CallFault) inject() error {
+	if f == nil {
+		return nil
+	}
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		return status.New(f.Code, f.Message).Err()
+	}
+	return nil
+}
+
+// FlakyWrite fails a bytestream Write for a specific digest partway through, once OffsetBytes of
+// it have been received, so a client's resumable-upload (QueryWriteStatus + retry) handling gets
+// exercised.
+type FlakyWrite struct {
+	// OffsetBytes is how many bytes of the write the fake accepts before failing it.
+	OffsetBytes int64
+	// Code is the status code the write fails with.
+	Code codes.Code
+	// Message is the status message returned alongside Code.
+	Message string
+	// Attempts bounds how many consecutive writes of this digest fail this way before the fake
+	// lets one through; zero means fail every write of this digest.
+	Attempts int
+}
+
+// faultInjector holds the per-digest FlakyWrite configuration shared by a CAS. It is split out
+// from CAS's main mutex since Write already holds its own bookkeeping state per call.
+type faultInjector struct {
+	mu       sync.Mutex
+	flaky    map[digest.Digest]*FlakyWrite
+	attempts map[digest.Digest]*int32
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{
+		flaky:    make(map[digest.Digest]*FlakyWrite),
+		attempts: make(map[digest.Digest]*int32),
+	}
+}
+
+func (f *faultInjector) setFlakyWrite(dg digest.Digest, fw *FlakyWrite) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fw == nil {
+		delete(f.flaky, dg)
+		delete(f.attempts, dg)
+		return
+	}
+	f.flaky[dg] = fw
+	f.attempts[dg] = new(int32)
+}
+
+// shouldFailAt reports whether the write of dg should fail once received has reached at least
+// OffsetBytes, returning the error to fail it with.
+func (f *faultInjector) shouldFailAt(dg digest.Digest, received int64) error {
+	f.mu.Lock()
+	fw, ok := f.flaky[dg]
+	counter := f.attempts[dg]
+	f.mu.Unlock()
+	if !ok || received < fw.OffsetBytes {
+		return nil
+	}
+	if fw.Attempts > 0 && atomic.AddInt32(counter, 1) > int32(fw.Attempts) {
+		return nil
+	}
+	return status.New(fw.Code, fw.Message).Err()
+}
+
+func (f *faultInjector) clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.flaky = make(map[digest.Digest]*FlakyWrite)
+	f.attempts = make(map[digest.Digest]*int32)
+}
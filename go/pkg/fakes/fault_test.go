@@ -0,0 +1,60 @@
+package fakes
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCallFaultNilInjectsNothing(t *testing.T) {
+	var f *CallFault
+	if err := f.inject(); err != nil {
+		t.Fatalf("nil CallFault.inject() = %v, want nil", err)
+	}
+}
+
+func TestCallFaultAlwaysFails(t *testing.T) {
+	f := &CallFault{ErrorRate: 1, Code: codes.Unavailable, Message: "down"}
+	if err := f.inject(); err == nil {
+		t.Fatal("inject() = nil, want an error with ErrorRate 1")
+	}
+}
+
+func TestFaultInjectorShouldFailAtOffset(t *testing.T) {
+	fi := newFaultInjector()
+	dg := digest.NewFromBlob([]byte("hello"))
+	fi.setFlakyWrite(dg, &FlakyWrite{OffsetBytes: 3, Code: codes.Aborted, Message: "flaky"})
+
+	if err := fi.shouldFailAt(dg, 2); err != nil {
+		t.Fatalf("shouldFailAt(dg, 2) = %v, want nil before reaching OffsetBytes", err)
+	}
+	if err := fi.shouldFailAt(dg, 3); err == nil {
+		t.Fatal("shouldFailAt(dg, 3) = nil, want an error once OffsetBytes is reached")
+	}
+}
+
+func TestFaultInjectorShouldFailAtRespectsAttempts(t *testing.T) {
+	fi := newFaultInjector()
+	dg := digest.NewFromBlob([]byte("hello"))
+	fi.setFlakyWrite(dg, &FlakyWrite{OffsetBytes: 0, Code: codes.Aborted, Message: "flaky", Attempts: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := fi.shouldFailAt(dg, 0); err == nil {
+			t.Fatalf("shouldFailAt attempt %d = nil, want an error within Attempts", i)
+		}
+	}
+	if err := fi.shouldFailAt(dg, 0); err != nil {
+		t.Fatalf("shouldFailAt attempt 3 = %v, want nil once Attempts is exceeded", err)
+	}
+}
+
+func TestFaultInjectorClearRemovesFlakyWrite(t *testing.T) {
+	fi := newFaultInjector()
+	dg := digest.NewFromBlob([]byte("hello"))
+	fi.setFlakyWrite(dg, &FlakyWrite{OffsetBytes: 0, Code: codes.Aborted, Message: "flaky"})
+	fi.clear()
+	if err := fi.shouldFailAt(dg, 0); err != nil {
+		t.Fatalf("shouldFailAt after clear() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,171 @@
+package fakes
+
+import (
+	"context"
+	"strings"
+
+	regrpc "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultInstance is the name of the instance a Server serves before any test directs a client
+// at a second one via Server.Instance.
+const defaultInstance = "instance"
+
+// InstanceState bundles the fake services backing a single named RBE instance, so a Server can
+// host several independently configured instances side by side, the way a real deployment
+// partitions traffic across instances with heterogeneous backends.
+type InstanceState struct {
+	Name        string
+	Exec        *Exec
+	CAS         *CAS
+	ActionCache *ActionCache
+
+	// SymlinkAbsolutePathStrategy is reported in this instance's ExecutionCapabilities, letting
+	// tests validate client behavior against instances with differing symlink policies.
+	SymlinkAbsolutePathStrategy repb.SymlinkAbsolutePathStrategy_Value
+}
+
+// newInstanceState returns a new, independently configurable instance named name.
+func newInstanceState(name string, opts ...ServerOpt) *InstanceState {
+	var cfg serverConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	c := NewCAS()
+	if cfg.casBackend != nil {
+		c = NewCASWithBackend(cfg.casBackend)
+	}
+	c.Compression = cfg.compress
+	ac := NewActionCache()
+	return &InstanceState{Name: name, CAS: c, ActionCache: ac, Exec: NewExec(ac, c, name)}
+}
+
+// getCapabilities implements the Capabilities service for this instance, layering its
+// ExecutionCapabilities on top of the CacheCapabilities reported by its CAS.
+func (inst *InstanceState) getCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	caps, err := inst.CAS.GetCapabilities(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	caps.ExecutionCapabilities = &repb.ExecutionCapabilities{
+		ExecEnabled:                 true,
+		DigestFunction:              repb.DigestFunction_SHA256,
+		SymlinkAbsolutePathStrategy: inst.SymlinkAbsolutePathStrategy,
+	}
+	return caps, nil
+}
+
+// instanceFromOperationName extracts the instance name a WaitExecution request's operation name
+// was generated under, mirroring how Exec.Execute builds it.
+func instanceFromOperationName(name string) string {
+	if i := strings.Index(name, "/operations/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// router demultiplexes incoming RE and ByteStream RPCs across a Server's instances, by
+// InstanceName for RE requests and by the resource-name instance prefix for bytestream ones, and
+// implements every fake gRPC service by delegating to the resolved instance.
+type router struct {
+	s *Server
+}
+
+// Execute implements the Execution service.
+func (r *router) Execute(req *repb.ExecuteRequest, stream regrpc.Execution_ExecuteServer) error {
+	return r.s.Instance(req.InstanceName).Exec.Execute(req, stream)
+}
+
+// WaitExecution implements the Execution service.
+func (r *router) WaitExecution(req *repb.WaitExecutionRequest, stream regrpc.Execution_WaitExecutionServer) error {
+	return r.s.Instance(instanceFromOperationName(req.Name)).Exec.WaitExecution(req, stream)
+}
+
+// FindMissingBlobs implements the CAS service.
+func (r *router) FindMissingBlobs(ctx context.Context, req *repb.FindMissingBlobsRequest) (*repb.FindMissingBlobsResponse, error) {
+	return r.s.Instance(req.InstanceName).CAS.FindMissingBlobs(ctx, req)
+}
+
+// BatchUpdateBlobs implements the CAS service.
+func (r *router) BatchUpdateBlobs(ctx context.Context, req *repb.BatchUpdateBlobsRequest) (*repb.BatchUpdateBlobsResponse, error) {
+	return r.s.Instance(req.InstanceName).CAS.BatchUpdateBlobs(ctx, req)
+}
+
+// BatchReadBlobs implements the CAS service.
+func (r *router) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	return r.s.Instance(req.InstanceName).CAS.BatchReadBlobs(ctx, req)
+}
+
+// GetTree implements the CAS service.
+func (r *router) GetTree(req *repb.GetTreeRequest, stream regrpc.ContentAddressableStorage_GetTreeServer) error {
+	return r.s.Instance(req.InstanceName).CAS.GetTree(req, stream)
+}
+
+// GetCapabilities implements the Capabilities service.
+func (r *router) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	return r.s.Instance(req.InstanceName).getCapabilities(ctx, req)
+}
+
+// GetActionResult implements the ActionCache service.
+func (r *router) GetActionResult(ctx context.Context, req *repb.GetActionResultRequest) (*repb.ActionResult, error) {
+	return r.s.Instance(req.InstanceName).ActionCache.GetActionResult(ctx, req)
+}
+
+// UpdateActionResult implements the ActionCache service.
+func (r *router) UpdateActionResult(ctx context.Context, req *repb.UpdateActionResultRequest) (*repb.ActionResult, error) {
+	return r.s.Instance(req.InstanceName).ActionCache.UpdateActionResult(ctx, req)
+}
+
+// Read implements the ByteStream service.
+func (r *router) Read(req *bsgrpc.ReadRequest, stream bsgrpc.ByteStream_ReadServer) error {
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return r.s.Instance(rn.instance).CAS.Read(req, stream)
+}
+
+// Write implements the ByteStream service. It peeks the first message to learn the resource
+// name's instance prefix, then replays it as the resolved instance's CAS.Write sees its own
+// first Recv.
+func (r *router) Write(stream bsgrpc.ByteStream_WriteServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return r.s.Instance(rn.instance).CAS.Write(&prefetchedWriteStream{ByteStream_WriteServer: stream, first: req})
+}
+
+// QueryWriteStatus implements the ByteStream service.
+func (r *router) QueryWriteStatus(ctx context.Context, req *bsgrpc.QueryWriteStatusRequest) (*bsgrpc.QueryWriteStatusResponse, error) {
+	rn, err := parseResourceName(req.ResourceName)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return r.s.Instance(rn.instance).CAS.QueryWriteStatus(ctx, req)
+}
+
+// prefetchedWriteStream replays an already-received WriteRequest as the first Recv, so the
+// router can inspect a Write's resource name to pick an instance without consuming the message
+// the delegated CAS.Write also needs to parse it from.
+type prefetchedWriteStream struct {
+	bsgrpc.ByteStream_WriteServer
+	first *bsgrpc.WriteRequest
+	used  bool
+}
+
+func (w *prefetchedWriteStream) Recv() (*bsgrpc.WriteRequest, error) {
+	if !w.used {
+		w.used = true
+		return w.first, nil
+	}
+	return w.ByteStream_WriteServer.Recv()
+}
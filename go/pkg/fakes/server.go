@@ -7,10 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/bazelbuild/remote-apis-sdks/go/digest"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/command"
+	fcas "github.com/bazelbuild/remote-apis-sdks/go/pkg/fakes/cas"
 	"github.com/bazelbuild/remote-apis-sdks/go/pkg/rexec"
 	"github.com/golang/protobuf/ptypes"
 	"google.golang.org/grpc"
@@ -23,38 +25,93 @@ import (
 	bsgrpc "google.golang.org/genproto/googleapis/bytestream"
 )
 
-// Server is a configurable fake in-process RBE server for use in integration tests.
+// Server is a configurable fake in-process RBE server for use in integration tests. It hosts one
+// or more named instances, each with its own CAS, ActionCache, and Exec, demultiplexed by the
+// instance_name field on incoming RE requests and by the instance prefix on bytestream resource
+// names. Exec, CAS, and ActionCache are the services backing its default instance, kept as
+// top-level fields so existing single-instance tests need not change.
 type Server struct {
 	Exec        *Exec
 	CAS         *CAS
 	ActionCache *ActionCache
-	listener    net.Listener
-	srv         *grpc.Server
+
+	instancesMu sync.RWMutex
+	instances   map[string]*InstanceState
+
+	listener net.Listener
+	srv      *grpc.Server
+}
+
+// serverConfig accumulates options applied before a Server's services are constructed.
+type serverConfig struct {
+	casBackend fcas.Backend
+	compress   bool
+}
+
+// ServerOpt configures a Server at construction time.
+type ServerOpt func(*serverConfig)
+
+// WithCompression makes the fake advertise zstd support in GetCapabilities and accept/serve the
+// compressed-blobs/zstd bytestream scheme and compressed batch requests.
+func WithCompression() ServerOpt {
+	return func(c *serverConfig) { c.compress = true }
+}
+
+// WithCASBackend makes the fake CAS store blobs in the given cas.Backend instead of the default
+// in-memory store, e.g. a cas.DiskBackend for tests whose inputs are too large to comfortably
+// keep in RAM.
+func WithCASBackend(backend fcas.Backend) ServerOpt {
+	return func(c *serverConfig) { c.casBackend = backend }
 }
 
-// NewServer creates a server that is ready to accept requests.
-func NewServer() (s *Server, err error) {
-	cas := NewCAS()
-	ac := NewActionCache()
-	s = &Server{Exec: NewExec(ac, cas), CAS: cas, ActionCache: ac}
+// NewServer creates a server that is ready to accept requests. The given options configure its
+// default instance; use Server.Instance to configure additional ones.
+func NewServer(opts ...ServerOpt) (s *Server, err error) {
+	def := newInstanceState(defaultInstance, opts...)
+	s = &Server{
+		Exec:        def.Exec,
+		CAS:         def.CAS,
+		ActionCache: def.ActionCache,
+		instances:   map[string]*InstanceState{defaultInstance: def},
+	}
 	s.listener, err = net.Listen("tcp", ":0")
 	if err != nil {
 		return nil, err
 	}
 	s.srv = grpc.NewServer()
-	bsgrpc.RegisterByteStreamServer(s.srv, s.CAS)
-	regrpc.RegisterContentAddressableStorageServer(s.srv, s.CAS)
-	regrpc.RegisterActionCacheServer(s.srv, s.ActionCache)
-	regrpc.RegisterExecutionServer(s.srv, s.Exec)
+	rt := &router{s: s}
+	bsgrpc.RegisterByteStreamServer(s.srv, rt)
+	regrpc.RegisterContentAddressableStorageServer(s.srv, rt)
+	regrpc.RegisterActionCacheServer(s.srv, rt)
+	regrpc.RegisterExecutionServer(s.srv, rt)
+	regrpc.RegisterCapabilitiesServer(s.srv, rt)
 	go s.srv.Serve(s.listener)
 	return s, nil
 }
 
-// Clear clears the fake results.
+// Instance returns the named instance's fake services, creating a new, independently empty
+// instance (with its own CAS, ActionCache, and Exec) on first use. Tests configure a secondary
+// instance this way before directing a client at it via NewTestClient.
+func (s *Server) Instance(name string) *InstanceState {
+	s.instancesMu.Lock()
+	defer s.instancesMu.Unlock()
+	if inst, ok := s.instances[name]; ok {
+		return inst
+	}
+	inst := newInstanceState(name)
+	s.instances[name] = inst
+	return inst
+}
+
+// Clear clears the fake results of every instance.
 func (s *Server) Clear() {
-	s.CAS.Clear()
-	s.ActionCache.Clear()
-	s.Exec.Clear()
+	s.instancesMu.RLock()
+	defer s.instancesMu.RUnlock()
+	for _, inst := range s.instances {
+		inst.CAS.Clear()
+		inst.ActionCache.Clear()
+		inst.Exec.Clear()
+	}
 }
 
 // Stop shuts down the in process server.
@@ -63,9 +120,9 @@ func (s *Server) Stop() {
 	s.srv.Stop()
 }
 
-// NewTestClient returns a new in-process Client connected to this server.
-func (s *Server) NewTestClient(ctx context.Context) (*rc.Client, error) {
-	return rc.Dial(ctx, "instance", rc.DialParams{
+// NewTestClient returns a new in-process Client connected to the named instance on this server.
+func (s *Server) NewTestClient(ctx context.Context, instance string) (*rc.Client, error) {
+	return rc.Dial(ctx, instance, rc.DialParams{
 		Service:    s.listener.Addr().String(),
 		NoSecurity: true,
 	})
@@ -94,7 +151,7 @@ func NewTestEnv(t *testing.T) (*TestEnv, func()) {
 	if err != nil {
 		t.Fatalf("Error starting fake server: %v", err)
 	}
-	grpcClient, err := s.NewTestClient(context.Background())
+	grpcClient, err := s.NewTestClient(context.Background(), defaultInstance)
 	if err != nil {
 		t.Fatalf("Error connecting to server: %v", err)
 	}
@@ -110,6 +167,13 @@ func NewTestEnv(t *testing.T) (*TestEnv, func()) {
 	}
 }
 
+// SetFlakyWrite configures the env's fake CAS to fail the next bytestream Write(s) of dg
+// partway through, as described by fw, so the client's resumable-upload handling gets
+// exercised. Pass a nil fw to clear any previously configured flakiness for dg.
+func (e *TestEnv) SetFlakyWrite(dg digest.Digest, fw *FlakyWrite) {
+	e.Server.CAS.SetFlakyWrite(dg, fw)
+}
+
 // Set sets up the fake to return the given result on the given command execution.
 // It is not possible to make the fake result in a LocalErrorResultStatus or an InterruptedResultStatus.
 func (e *TestEnv) Set(cmd *command.Command, opt *command.ExecutionOptions, res *command.Result, opts ...option) (cmdDg, acDg digest.Digest) {
@@ -171,22 +235,28 @@ type OutputFile struct {
 	Contents string
 }
 
-// Apply puts the file in the fake CAS and the given ActionResult.
+// Apply puts the file in the fake CAS and the given ActionResult. If the server has
+// Compression enabled, the file is also stored as a precomputed zstd-compressed variant so a
+// client reading it exercises its compressed download path. The digest is leased so it survives
+// a cas.DiskBackend Prune call simulating cache pressure, until the next Clear.
 func (f *OutputFile) Apply(ac *repb.ActionResult, s *Server) {
 	bytes := []byte(f.Contents)
 	s.Exec.OutputBlobs = append(s.Exec.OutputBlobs, bytes)
-	dg := s.CAS.Put(bytes)
+	dg := s.CAS.put(bytes)
+	s.Exec.leaseOutput(dg)
 	ac.OutputFiles = append(ac.OutputFiles, &repb.OutputFile{Path: f.Path, Digest: dg.ToProto()})
 }
 
 // StdOut is to be added as an output of the fake action.
 type StdOut string
 
-// Apply puts the action stdout in the fake CAS and the given ActionResult.
+// Apply puts the action stdout in the fake CAS and the given ActionResult, leasing its digest
+// the same way OutputFile does.
 func (o StdOut) Apply(ac *repb.ActionResult, s *Server) {
 	bytes := []byte(o)
 	s.Exec.OutputBlobs = append(s.Exec.OutputBlobs, bytes)
-	dg := s.CAS.Put(bytes)
+	dg := s.CAS.put(bytes)
+	s.Exec.leaseOutput(dg)
 	ac.StdoutDigest = dg.ToProto()
 }
 
@@ -201,11 +271,13 @@ func (o StdOutRaw) Apply(ac *repb.ActionResult, s *Server) {
 // StdErr is to be added as an output of the fake action.
 type StdErr string
 
-// Apply puts the action stderr in the fake CAS and the given ActionResult.
+// Apply puts the action stderr in the fake CAS and the given ActionResult, leasing its digest
+// the same way OutputFile does.
 func (o StdErr) Apply(ac *repb.ActionResult, s *Server) {
 	bytes := []byte(o)
 	s.Exec.OutputBlobs = append(s.Exec.OutputBlobs, bytes)
-	dg := s.CAS.Put(bytes)
+	dg := s.CAS.put(bytes)
+	s.Exec.leaseOutput(dg)
 	ac.StderrDigest = dg.ToProto()
 }
 
@@ -0,0 +1,58 @@
+package fakes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/remote-apis-sdks/go/digest"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func TestServerInstanceIsIndependentOfDefault(t *testing.T) {
+	s := newTestServer(t)
+	other := s.Instance("other")
+
+	dg := s.CAS.Put([]byte("only on default"))
+	if other.CAS.Contains(dg) {
+		t.Errorf("secondary instance sees a blob only Put on the default instance's CAS")
+	}
+
+	otherDg := other.CAS.Put([]byte("only on other"))
+	if s.CAS.Contains(otherDg) {
+		t.Errorf("default instance sees a blob only Put on a secondary instance's CAS")
+	}
+}
+
+func TestServerInstanceIsStableAcrossCalls(t *testing.T) {
+	s := newTestServer(t)
+	first := s.Instance("other")
+	second := s.Instance("other")
+	if first != second {
+		t.Errorf("Instance(%q) returned a different *InstanceState on a second call", "other")
+	}
+}
+
+func TestInstanceStateActionLogIsIndependentOfDefault(t *testing.T) {
+	s := newTestServer(t)
+	other := s.Instance("other")
+
+	e := ActionEvent{ActionDigest: digest.NewFromBlob([]byte("on other instance"))}
+	other.Exec.log.append(e)
+
+	if len(s.ActionLog()) != 0 {
+		t.Errorf("Server.ActionLog() = %v, want empty; the event was logged on a secondary instance", s.ActionLog())
+	}
+	got := other.ActionLog()
+	if len(got) != 1 || !reflect.DeepEqual(got[0], e) {
+		t.Fatalf("InstanceState.ActionLog() = %v, want [%v]", got, e)
+	}
+}